@@ -0,0 +1,68 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// box is a generic, unparsed ISO/IEC 14496-12 box. It is used where a fully
+// structured type isn't needed, such as walking an init segment to find the
+// `stsd` for a track, or splitting a stored fragment into its top-level
+// boxes before handing the `moof` off to fmp4io for structured parsing.
+type box struct {
+	Type [4]byte
+	// Raw is the complete box, including its header.
+	Raw []byte
+	// Body is Raw with the header (and largesize, if present) stripped.
+	Body []byte
+}
+
+func (b box) typeString() string { return string(b.Type[:]) }
+
+// parseBoxes splits a contiguous run of sibling boxes, such as the children
+// of a `moov` or the top level of an init segment, into individual boxes.
+func parseBoxes(data []byte) ([]box, error) {
+	var out []box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("fmp4: truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		hdr := 8
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("fmp4: truncated largesize box header")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			hdr = 16
+		}
+		if size < uint64(hdr) || size > uint64(len(data)) {
+			return nil, fmt.Errorf("fmp4: box size %d out of range", size)
+		}
+		var b box
+		copy(b.Type[:], data[4:8])
+		b.Raw = data[:size]
+		b.Body = data[hdr:size]
+		out = append(out, b)
+		data = data[size:]
+	}
+	return out, nil
+}
+
+// findBox returns the first child box of the given four-character type.
+func findBox(boxes []box, typ string) (box, bool) {
+	for _, b := range boxes {
+		if b.typeString() == typ {
+			return b, true
+		}
+	}
+	return box{}, false
+}
+
+// childBoxes parses the body of box b as a sequence of child boxes.
+func childBoxes(b box) ([]box, error) {
+	return parseBoxes(b.Body)
+}
@@ -0,0 +1,63 @@
+package fmp4
+
+import (
+	"github.com/nareix/joy4/av"
+)
+
+// Brands picks the `ftyp` major brand and compatible-brands list for an
+// init segment carrying the given codecs, following the CMAF brand rules:
+// a single AVC video track gets `cmfc`, a single HEVC video track gets
+// `cmf2`, and a single AAC audio track gets `caac` (with the common alias
+// `cmfa` alongside it); any other mix, including several AAC tracks, gets
+// only the baseline brand. `iso6` is always included as the CMAF baseline brand so
+// strict CMAF/DASH players (dash.js, Shaka in CMAF mode) accept the
+// segment even when none of the more specific brands apply.
+//
+// Brands only looks at codecs. A caller whose fragments use a version-1
+// trun with negative composition offsets or an edit list should also add
+// "iso5" to the returned compat list before passing it to FtypBox.
+func Brands(codecs []av.CodecData) (major string, compat []string) {
+	compat = []string{"iso6"}
+	switch major = cmafBrand(codecs); major {
+	case "":
+		// no single CMAF brand fits this codec combination; fall back to
+		// the baseline brand
+		major = "iso6"
+	case "caac":
+		compat = append(compat, major, "cmfa")
+	default:
+		compat = append(compat, major)
+	}
+	return major, compat
+}
+
+// FtypBox marshals an `ftyp` box with the given major and compatible
+// brands, as returned by Brands.
+func FtypBox(major string, compat []string) []byte {
+	payload := make([]byte, 8+4*len(compat))
+	copy(payload[0:4], major)
+	// minor_version is left at 0: CMAF doesn't assign it a meaning
+	for i, b := range compat {
+		copy(payload[8+4*i:], b)
+	}
+	return makeBox("ftyp", payload)
+}
+
+// cmafBrand returns the CMAF major brand for a single-track init segment,
+// or "" if the codec mix doesn't correspond to one of the defined brands
+// (e.g. multiple video tracks, or a codec CMAF doesn't define a brand for).
+func cmafBrand(codecs []av.CodecData) string {
+	if len(codecs) != 1 {
+		return ""
+	}
+	switch codecs[0].Type() {
+	case av.H264:
+		return "cmfc"
+	case av.H265:
+		return "cmf2"
+	case av.AAC:
+		return "caac"
+	default:
+		return ""
+	}
+}
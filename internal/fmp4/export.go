@@ -0,0 +1,591 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ExportSample is a single reconstructed sample, ready to be placed in the
+// `mdat` of a progressive-download export.
+type ExportSample struct {
+	Duration uint32 // in the track's timescale
+	CTS      int32  // composition time offset, in the track's timescale
+	Sync     bool
+	Data     []byte
+}
+
+// ExportTrack carries everything needed to synthesize one `trak` of a
+// fast-start MP4: the sample table copied/derived from the fragments that
+// make up a segment, plus the `stsd` describing the codec, taken verbatim
+// from the init segment.
+type ExportTrack struct {
+	ID        uint32
+	TimeScale uint32
+	Stsd      []byte // raw `stsd` box, including its header, copied from the init segment
+	Audio     bool
+	Samples   []ExportSample
+}
+
+// ReadInitTracks extracts the per-track timescale, ID, and `stsd` box from
+// an fMP4 init segment, so they can be reused verbatim when assembling a
+// progressive export. The sample data itself comes from the fragments
+// written after the init segment, not from here.
+func ReadInitTracks(init []byte) (map[uint32]*ExportTrack, error) {
+	top, err := parseBoxes(init)
+	if err != nil {
+		return nil, err
+	}
+	moovBox, ok := findBox(top, "moov")
+	if !ok {
+		return nil, errors.New("fmp4: init segment has no moov")
+	}
+	moovChildren, err := childBoxes(moovBox)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make(map[uint32]*ExportTrack)
+	for _, trak := range moovChildren {
+		if trak.typeString() != "trak" {
+			continue
+		}
+		trakChildren, err := childBoxes(trak)
+		if err != nil {
+			return nil, err
+		}
+		tkhd, ok := findBox(trakChildren, "tkhd")
+		if !ok {
+			return nil, errors.New("fmp4: trak has no tkhd")
+		}
+		id, err := trackIDFromTKHD(tkhd.Body)
+		if err != nil {
+			return nil, err
+		}
+		mdia, ok := findBox(trakChildren, "mdia")
+		if !ok {
+			return nil, errors.New("fmp4: trak has no mdia")
+		}
+		mdiaChildren, err := childBoxes(mdia)
+		if err != nil {
+			return nil, err
+		}
+		mdhd, ok := findBox(mdiaChildren, "mdhd")
+		if !ok {
+			return nil, errors.New("fmp4: mdia has no mdhd")
+		}
+		ts, err := timeScaleFromMDHD(mdhd.Body)
+		if err != nil {
+			return nil, err
+		}
+		hdlr, _ := findBox(mdiaChildren, "hdlr")
+		minf, ok := findBox(mdiaChildren, "minf")
+		if !ok {
+			return nil, errors.New("fmp4: mdia has no minf")
+		}
+		minfChildren, err := childBoxes(minf)
+		if err != nil {
+			return nil, err
+		}
+		stbl, ok := findBox(minfChildren, "stbl")
+		if !ok {
+			return nil, errors.New("fmp4: minf has no stbl")
+		}
+		stblChildren, err := childBoxes(stbl)
+		if err != nil {
+			return nil, err
+		}
+		stsd, ok := findBox(stblChildren, "stsd")
+		if !ok {
+			return nil, errors.New("fmp4: stbl has no stsd")
+		}
+		tracks[id] = &ExportTrack{
+			ID:        id,
+			TimeScale: ts,
+			Stsd:      stsd.Raw,
+			Audio:     len(hdlr.Body) >= 12 && string(hdlr.Body[8:12]) == "soun",
+		}
+	}
+	return tracks, nil
+}
+
+func trackIDFromTKHD(body []byte) (uint32, error) {
+	if len(body) < 4 {
+		return 0, errors.New("fmp4: truncated tkhd")
+	}
+	version := body[0]
+	if version == 1 {
+		if len(body) < 4+8+8+4 {
+			return 0, errors.New("fmp4: truncated tkhd v1")
+		}
+		return binary.BigEndian.Uint32(body[20:24]), nil
+	}
+	if len(body) < 4+4+4+4 {
+		return 0, errors.New("fmp4: truncated tkhd v0")
+	}
+	return binary.BigEndian.Uint32(body[12:16]), nil
+}
+
+func timeScaleFromMDHD(body []byte) (uint32, error) {
+	if len(body) < 4 {
+		return 0, errors.New("fmp4: truncated mdhd")
+	}
+	version := body[0]
+	if version == 1 {
+		if len(body) < 4+8+8+4 {
+			return 0, errors.New("fmp4: truncated mdhd v1")
+		}
+		return binary.BigEndian.Uint32(body[20:24]), nil
+	}
+	if len(body) < 4+4+4+4 {
+		return 0, errors.New("fmp4: truncated mdhd v0")
+	}
+	return binary.BigEndian.Uint32(body[12:16]), nil
+}
+
+// ReadFragmentSamples splits one stored fMP4 fragment (a `moof` followed by
+// its `mdat`) into the samples it contains, grouped by track ID, for use by
+// Export. It is a thin adapter over ParseFragment, which does the actual
+// trun/tfhd decoding.
+func ReadFragmentSamples(data []byte) (map[uint32][]ExportSample, error) {
+	frag, err := ParseFragment(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[uint32][]ExportSample, len(frag.Tracks))
+	for _, track := range frag.Tracks {
+		samples := make([]ExportSample, len(track.Samples))
+		for i, pkt := range track.Samples {
+			samples[i] = ExportSample{
+				Duration: pkt.Duration,
+				CTS:      pkt.CompositionTime,
+				Sync:     pkt.IsKeyFrame,
+				Data:     pkt.Data,
+			}
+		}
+		out[track.TrackID] = samples
+	}
+	return out, nil
+}
+
+// makeBox wraps payload in a standard (non-full) box header.
+func makeBox(typ string, payload []byte) []byte {
+	out := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint32(out, uint32(len(out)))
+	copy(out[4:8], typ)
+	copy(out[8:], payload)
+	return out
+}
+
+// makeFullBox wraps payload in a version-0 "full box" header (version + flags).
+func makeFullBox(typ string, flags uint32, payload []byte) []byte {
+	return makeVersionedFullBox(typ, 0, flags, payload)
+}
+
+// makeVersionedFullBox wraps payload in a "full box" header (version + flags)
+// with an explicit version, for boxes such as `sidx` whose field widths
+// depend on it.
+func makeVersionedFullBox(typ string, version byte, flags uint32, payload []byte) []byte {
+	fb := make([]byte, 4+len(payload))
+	fb[0] = version
+	fb[1], fb[2], fb[3] = byte(flags>>16), byte(flags>>8), byte(flags)
+	copy(fb[4:], payload)
+	return makeBox(typ, fb)
+}
+
+var identityMatrix = []byte{
+	0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0x40, 0, 0, 0,
+}
+
+// Export writes a fast-start, non-fragmented MP4 assembled from a set of
+// tracks, each already populated with the samples drawn from one or more
+// finalized segments and the `stsd` copied from the init segment. `moov` is
+// written before `mdat` so players and CDNs can start progressive download
+// without seeking to the end of the file.
+func Export(w io.Writer, tracks []*ExportTrack) error {
+	ftyp := makeBox("ftyp", append([]byte("isom\x00\x00\x02\x00"), []byte("isomiso6mp41")...))
+	moov := buildMoov(tracks)
+	// chunk offsets in stco/co64 point into mdat, which is written after
+	// moov; moov's own size does not depend on those offset values, so a
+	// single pass that patches the offsets after moov is already sized
+	// suffices instead of reserializing moov a second time.
+	sampleDataStart := int64(len(ftyp)) + int64(len(moov)) + 8 // past the mdat box header: the first sample byte
+	patchChunkOffsets(moov, tracks, sampleDataStart)
+	var mdatSize int64
+	for _, t := range tracks {
+		for _, s := range t.Samples {
+			mdatSize += int64(len(s.Data))
+		}
+	}
+	mdatHeader := make([]byte, 8)
+	binary.BigEndian.PutUint32(mdatHeader, uint32(8+mdatSize))
+	copy(mdatHeader[4:], "mdat")
+	for _, b := range [][]byte{ftyp, moov, mdatHeader} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	for _, t := range tracks {
+		for _, s := range t.Samples {
+			if _, err := w.Write(s.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func buildMoov(tracks []*ExportTrack) []byte {
+	var duration uint32 = 1000
+	for _, t := range tracks {
+		if d := trackDuration(t); d > duration {
+			duration = d
+		}
+	}
+	mvhd := makeFullBox("mvhd", 0, mvhdPayload(duration))
+	var traks [][]byte
+	for _, t := range tracks {
+		traks = append(traks, buildTrak(t, duration))
+	}
+	payload := mvhd
+	for _, t := range traks {
+		payload = append(payload, t...)
+	}
+	return makeBox("moov", payload)
+}
+
+// trackDuration returns the track's duration in the 1000Hz "movie"
+// timescale used by mvhd.
+func trackDuration(t *ExportTrack) uint32 {
+	var ticks int64
+	for _, s := range t.Samples {
+		ticks += int64(s.Duration)
+	}
+	if t.TimeScale == 0 {
+		return 0
+	}
+	return uint32(ticks * 1000 / int64(t.TimeScale))
+}
+
+func mvhdPayload(duration uint32) []byte {
+	b := make([]byte, 96)
+	binary.BigEndian.PutUint32(b[8:], 1000) // timescale
+	binary.BigEndian.PutUint32(b[12:], duration)
+	binary.BigEndian.PutUint32(b[16:], 0x00010000) // rate
+	binary.BigEndian.PutUint16(b[20:], 0x0100)     // volume
+	copy(b[32:68], identityMatrix)                 // reserved(2)+reserved(8) precede the matrix, not after volume
+	binary.BigEndian.PutUint32(b[92:], 0xFFFFFFFF) // next_track_ID: unused
+	return b
+}
+
+func buildTrak(t *ExportTrack, movieDuration uint32) []byte {
+	duration := trackDuration(t)
+	tkhd := makeFullBox("tkhd", 7, tkhdPayload(t, duration)) // flags: enabled|in movie|in preview
+	mdia := buildMdia(t)
+	payload := append(tkhd, mdia...)
+	if elst := buildEdts(t, movieDuration); elst != nil {
+		payload = append(payload, elst...)
+	}
+	return makeBox("trak", payload)
+}
+
+func tkhdPayload(t *ExportTrack, duration uint32) []byte {
+	b := make([]byte, 80)
+	binary.BigEndian.PutUint32(b[8:], t.ID)
+	binary.BigEndian.PutUint32(b[16:], duration)
+	if t.Audio {
+		binary.BigEndian.PutUint16(b[32:], 0x0100) // volume
+	}
+	copy(b[36:72], identityMatrix)
+	return b
+}
+
+func buildMdia(t *ExportTrack) []byte {
+	mdhd := makeFullBox("mdhd", 0, mdhdPayload(t))
+	handlerType, name := "vide", "VideoHandler"
+	if t.Audio {
+		handlerType, name = "soun", "SoundHandler"
+	}
+	hdlr := makeFullBox("hdlr", 0, hdlrPayload(handlerType, name))
+	minf := buildMinf(t)
+	payload := append(mdhd, hdlr...)
+	payload = append(payload, minf...)
+	return makeBox("mdia", payload)
+}
+
+func mdhdPayload(t *ExportTrack) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint32(b[8:], t.TimeScale)
+	var ticks uint32
+	for _, s := range t.Samples {
+		ticks += s.Duration
+	}
+	binary.BigEndian.PutUint32(b[12:], ticks)
+	binary.BigEndian.PutUint16(b[16:], 0x55c4) // language: und
+	return b
+}
+
+func hdlrPayload(handlerType, name string) []byte {
+	b := make([]byte, 20+len(name)+1)
+	copy(b[4:8], handlerType)
+	copy(b[20:], name)
+	return b
+}
+
+func buildMinf(t *ExportTrack) []byte {
+	var mhd []byte
+	if t.Audio {
+		mhd = makeFullBox("smhd", 0, make([]byte, 4))
+	} else {
+		mhd = makeFullBox("vmhd", 1, make([]byte, 8))
+	}
+	dref := makeFullBox("dref", 0, append(uint32b(1), makeFullBox("url ", 1, nil)...))
+	dinf := makeBox("dinf", dref)
+	stbl := buildStbl(t)
+	payload := append(mhd, dinf...)
+	payload = append(payload, stbl...)
+	return makeBox("minf", payload)
+}
+
+func uint32b(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
+
+func buildStbl(t *ExportTrack) []byte {
+	payload := append([]byte{}, t.Stsd...)
+	payload = append(payload, buildStts(t)...)
+	if ctts := buildCtts(t); ctts != nil {
+		payload = append(payload, ctts...)
+	}
+	if stss := buildStss(t); stss != nil {
+		payload = append(payload, stss...)
+	}
+	payload = append(payload, buildStsz(t)...)
+	payload = append(payload, buildStsc(t)...)
+	payload = append(payload, buildChunkOffsetBox(t)...)
+	return makeBox("stbl", payload)
+}
+
+// buildStsc writes the mandatory SampleToChunkBox. Every sample is its own
+// chunk (see buildChunkOffsetBox), so a single entry covers the whole
+// track: first_chunk=1, samples_per_chunk=1, sample_description_index=1.
+func buildStsc(t *ExportTrack) []byte {
+	if len(t.Samples) == 0 {
+		return makeFullBox("stsc", 0, uint32b(0))
+	}
+	payload := uint32b(1)
+	payload = append(payload, uint32b(1)...) // first_chunk
+	payload = append(payload, uint32b(1)...) // samples_per_chunk
+	payload = append(payload, uint32b(1)...) // sample_description_index
+	return makeFullBox("stsc", 0, payload)
+}
+
+// runLengthEntry is one (count, value) pair shared by the run-length coded
+// stts/ctts tables.
+type runLengthEntry struct {
+	count int64
+	value int64
+}
+
+func runLengthEncode(values []int64) []runLengthEntry {
+	var out []runLengthEntry
+	for _, v := range values {
+		if n := len(out); n > 0 && out[n-1].value == v {
+			out[n-1].count++
+			continue
+		}
+		out = append(out, runLengthEntry{count: 1, value: v})
+	}
+	return out
+}
+
+func buildStts(t *ExportTrack) []byte {
+	durations := make([]int64, len(t.Samples))
+	for i, s := range t.Samples {
+		durations[i] = int64(s.Duration)
+	}
+	entries := runLengthEncode(durations)
+	payload := uint32b(uint32(len(entries)))
+	for _, e := range entries {
+		payload = append(payload, uint32b(uint32(e.count))...)
+		payload = append(payload, uint32b(uint32(e.value))...)
+	}
+	return makeFullBox("stts", 0, payload)
+}
+
+func buildCtts(t *ExportTrack) []byte {
+	var any bool
+	cts := make([]int64, len(t.Samples))
+	for i, s := range t.Samples {
+		cts[i] = int64(s.CTS)
+		if s.CTS != 0 {
+			any = true
+		}
+	}
+	if !any {
+		return nil
+	}
+	entries := runLengthEncode(cts)
+	payload := uint32b(uint32(len(entries)))
+	for _, e := range entries {
+		payload = append(payload, uint32b(uint32(e.count))...)
+		payload = append(payload, uint32b(uint32(int32(e.value)))...)
+	}
+	return makeFullBox("ctts", 1, payload) // version 1: signed sample_offset, matches negative CTS in version-1 trun
+}
+
+func buildStss(t *ExportTrack) []byte {
+	var syncs []uint32
+	allSync := true
+	for i, s := range t.Samples {
+		if s.Sync {
+			syncs = append(syncs, uint32(i+1))
+		} else {
+			allSync = false
+		}
+	}
+	if allSync {
+		// every sample is a sync sample (typical for audio): omit stss entirely
+		return nil
+	}
+	payload := uint32b(uint32(len(syncs)))
+	for _, n := range syncs {
+		payload = append(payload, uint32b(n)...)
+	}
+	return makeFullBox("stss", 0, payload)
+}
+
+func buildStsz(t *ExportTrack) []byte {
+	payload := uint32b(0) // sample_size: 0 means sizes are given per-sample below
+	payload = append(payload, uint32b(uint32(len(t.Samples)))...)
+	for _, s := range t.Samples {
+		payload = append(payload, uint32b(uint32(len(s.Data)))...)
+	}
+	return makeFullBox("stsz", 0, payload)
+}
+
+// buildChunkOffsetBox writes one chunk per sample with a placeholder (zero)
+// offset; patchChunkOffsets fills in the real file offsets once the size of
+// moov (and hence the start of mdat) is known.
+func buildChunkOffsetBox(t *ExportTrack) []byte {
+	n := len(t.Samples)
+	payload := uint32b(uint32(n))
+	payload = append(payload, make([]byte, 8*n)...)
+	return makeFullBox("co64", 0, payload)
+}
+
+func buildEdts(t *ExportTrack, movieDuration uint32) []byte {
+	if len(t.Samples) == 0 || t.Samples[0].CTS == 0 {
+		return nil
+	}
+	// shift the presentation timeline so the first sample's composition
+	// time lands at zero, matching the offset baked into the fragments
+	entry := make([]byte, 20)
+	binary.BigEndian.PutUint64(entry[0:8], uint64(movieDuration))
+	binary.BigEndian.PutUint64(entry[8:16], uint64(int64(t.Samples[0].CTS)))
+	binary.BigEndian.PutUint32(entry[16:20], 0x00010000) // media_rate = 1.0
+	payload := append(uint32b(1), entry...)
+	elst := makeFullBox("elst", 1, payload) // version 1: 64-bit segment_duration/media_time
+	return makeBox("edts", elst)
+}
+
+// patchChunkOffsets overwrites the placeholder co64 entries written by
+// buildChunkOffsetBox with the real offsets into the final file, now that
+// the size (and thus position) of everything before mdat is known.
+// sampleDataStart is the offset of the first sample byte, i.e. already past
+// the mdat box header.
+func patchChunkOffsets(moov []byte, tracks []*ExportTrack, sampleDataStart int64) {
+	off := sampleDataStart
+	for _, t := range tracks {
+		entries := findTrackCo64(moov, t.ID, len(t.Samples))
+		for i, s := range t.Samples {
+			if entries != nil {
+				binary.BigEndian.PutUint64(entries[i], uint64(off))
+			}
+			off += int64(len(s.Data))
+		}
+	}
+}
+
+// findTrackCo64 locates the N 8-byte offset slots of the co64 box belonging
+// to the track with the given ID, in moov order, and returns sub-slices of
+// moov that can be written through directly.
+func findTrackCo64(moov []byte, trackID uint32, count int) [][]byte {
+	top, err := parseBoxes(moov[8:])
+	if err != nil {
+		return nil
+	}
+	var traks []box
+	for _, b := range top {
+		if b.typeString() == "trak" {
+			traks = append(traks, b)
+		}
+	}
+	for i, trak := range traks {
+		id, err := trakTrackID(trak)
+		if err != nil || id != trackID {
+			_ = i
+			continue
+		}
+		return locateCo64(trak)
+	}
+	return nil
+}
+
+func trakTrackID(trak box) (uint32, error) {
+	children, err := childBoxes(trak)
+	if err != nil {
+		return 0, err
+	}
+	tkhd, ok := findBox(children, "tkhd")
+	if !ok {
+		return 0, errors.New("fmp4: trak has no tkhd")
+	}
+	return trackIDFromTKHD(tkhd.Body)
+}
+
+func locateCo64(trak box) [][]byte {
+	children, err := childBoxes(trak)
+	if err != nil {
+		return nil
+	}
+	mdia, ok := findBox(children, "mdia")
+	if !ok {
+		return nil
+	}
+	mdiaChildren, err := childBoxes(mdia)
+	if err != nil {
+		return nil
+	}
+	minf, ok := findBox(mdiaChildren, "minf")
+	if !ok {
+		return nil
+	}
+	minfChildren, err := childBoxes(minf)
+	if err != nil {
+		return nil
+	}
+	stbl, ok := findBox(minfChildren, "stbl")
+	if !ok {
+		return nil
+	}
+	stblChildren, err := childBoxes(stbl)
+	if err != nil {
+		return nil
+	}
+	co64, ok := findBox(stblChildren, "co64")
+	if !ok {
+		return nil
+	}
+	count := binary.BigEndian.Uint32(co64.Body[4:8])
+	out := make([][]byte, count)
+	for i := range out {
+		start := 8 + i*8
+		out[i] = co64.Body[start : start+8]
+	}
+	return out
+}
@@ -0,0 +1,73 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestExportChunkOffsets builds a two-track export with known sample data,
+// then re-parses the output's co64 entries and checks that the bytes at
+// each patched offset are exactly the corresponding input sample: this is
+// the round trip that would have caught the off-by-8 bug in
+// patchChunkOffsets (every offset pointing 8 bytes past the real start of
+// its sample).
+func TestExportChunkOffsets(t *testing.T) {
+	video := &ExportTrack{
+		ID:        1,
+		TimeScale: 90000,
+		Stsd:      makeFullBox("stsd", 0, uint32b(0)),
+		Samples: []ExportSample{
+			{Duration: 3000, Sync: true, Data: []byte("video-sample-0")},
+			{Duration: 3000, Data: []byte("v1")},
+			{Duration: 3000, Data: []byte("video-sample-two")},
+		},
+	}
+	audio := &ExportTrack{
+		ID:        2,
+		TimeScale: 48000,
+		Audio:     true,
+		Stsd:      makeFullBox("stsd", 0, uint32b(0)),
+		Samples: []ExportSample{
+			{Duration: 1024, Sync: true, Data: []byte("a0")},
+			{Duration: 1024, Data: []byte("audio-sample-one")},
+		},
+	}
+	tracks := []*ExportTrack{video, audio}
+
+	var out bytes.Buffer
+	if err := Export(&out, tracks); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	output := out.Bytes()
+
+	top, err := parseBoxes(output)
+	if err != nil {
+		t.Fatalf("parseBoxes(output): %v", err)
+	}
+	moovBox, ok := findBox(top, "moov")
+	if !ok {
+		t.Fatal("no moov in exported output")
+	}
+
+	for _, track := range tracks {
+		entries := findTrackCo64(moovBox.Raw, track.ID, len(track.Samples))
+		if entries == nil {
+			t.Fatalf("track %d: no co64 entries found", track.ID)
+		}
+		if len(entries) != len(track.Samples) {
+			t.Fatalf("track %d: got %d co64 entries, want %d", track.ID, len(entries), len(track.Samples))
+		}
+		for i, s := range track.Samples {
+			off := binary.BigEndian.Uint64(entries[i])
+			if off+uint64(len(s.Data)) > uint64(len(output)) {
+				t.Fatalf("track %d sample %d: offset %d + len %d runs past end of output (%d bytes)",
+					track.ID, i, off, len(s.Data), len(output))
+			}
+			got := output[off : off+uint64(len(s.Data))]
+			if !bytes.Equal(got, s.Data) {
+				t.Errorf("track %d sample %d: bytes at offset %d = %q, want %q", track.ID, i, off, got, s.Data)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package fmp4
+
+import "time"
+
+// RawFragment is a fully marshaled `moof`+`mdat` pair, as produced by
+// writeFragment and appended to a segment.
+type RawFragment struct {
+	Bytes       []byte
+	Length      int
+	Duration    time.Duration
+	Independent bool
+	// Offset is this part's byte offset within the segment's whole-segment
+	// file, populated by Segment.Append. Once the segment is finalized, it
+	// lets a part be served as a byte range of the single `.m4s` file
+	// instead of needing a file of its own.
+	Offset int64
+	// NTP is the wallclock time of the first sample in the fragment, taken
+	// from the packet/fragment that produced it (e.g. an RTCP sender report
+	// for RTSP, or an RTMP absolute timestamp). It is zero if no wallclock
+	// anchor was available. Segment.Format uses it to emit a fresh
+	// #EXT-X-PROGRAM-DATE-TIME ahead of a part whenever it differs by more
+	// than a small epsilon from the last wallclock time written for the
+	// segment, instead of relying on a single anchor captured once per
+	// segment.
+	NTP time.Time
+}
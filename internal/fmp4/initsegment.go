@@ -0,0 +1,111 @@
+package fmp4
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/nareix/joy4/av"
+)
+
+// InitTrack describes one track of an init segment: enough to build its
+// `trak` (no sample tables yet, since no fragments have arrived) and to let
+// WriteInitSegment pick the right CMAF brand for the `ftyp`.
+type InitTrack struct {
+	ID        uint32
+	TimeScale uint32
+	Stsd      []byte // raw `stsd` box, including its header
+	Audio     bool
+	Codec     av.CodecData
+}
+
+// WriteInitSegment writes an fMP4 init segment: a `ftyp` whose brand is
+// picked by Brands from the tracks' codecs, followed by a `moov` with one
+// empty `trak` per track and an `mvex`/`trex` so players know to expect
+// fragments. hasNegativeCTS should be true when the fragments that will
+// follow use a version-1 trun with negative composition offsets or an
+// edit list, which adds the "iso5" compatible brand.
+func WriteInitSegment(w io.Writer, tracks []*InitTrack, hasNegativeCTS bool) error {
+	codecs := make([]av.CodecData, len(tracks))
+	for i, t := range tracks {
+		codecs[i] = t.Codec
+	}
+	major, compat := Brands(codecs)
+	if hasNegativeCTS {
+		compat = append(compat, "iso5")
+	}
+	ftyp := FtypBox(major, compat)
+	moov := buildInitMoov(tracks)
+	for _, b := range [][]byte{ftyp, moov} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildInitMoov(tracks []*InitTrack) []byte {
+	mvhd := makeFullBox("mvhd", 0, mvhdPayload(0))
+	payload := mvhd
+	for _, t := range tracks {
+		payload = append(payload, buildInitTrak(t)...)
+	}
+	payload = append(payload, buildMvex(tracks)...)
+	return makeBox("moov", payload)
+}
+
+func buildInitTrak(t *InitTrack) []byte {
+	tkhd := makeFullBox("tkhd", 7, tkhdPayload(&ExportTrack{ID: t.ID, Audio: t.Audio}, 0))
+	mdhd := makeFullBox("mdhd", 0, mdhdPayload(&ExportTrack{TimeScale: t.TimeScale}))
+	handlerType, name := "vide", "VideoHandler"
+	if t.Audio {
+		handlerType, name = "soun", "SoundHandler"
+	}
+	hdlr := makeFullBox("hdlr", 0, hdlrPayload(handlerType, name))
+	minf := buildInitMinf(t)
+	payload := append(tkhd, mdhd...)
+	payload = append(payload, hdlr...)
+	payload = append(payload, minf...)
+	return makeBox("trak", payload)
+}
+
+func buildInitMinf(t *InitTrack) []byte {
+	var mhd []byte
+	if t.Audio {
+		mhd = makeFullBox("smhd", 0, make([]byte, 4))
+	} else {
+		mhd = makeFullBox("vmhd", 1, make([]byte, 8))
+	}
+	dref := makeFullBox("dref", 0, append(uint32b(1), makeFullBox("url ", 1, nil)...))
+	dinf := makeBox("dinf", dref)
+	stbl := buildInitStbl(t)
+	payload := append(mhd, dinf...)
+	payload = append(payload, stbl...)
+	return makeBox("minf", payload)
+}
+
+// buildInitStbl writes a sample table with no entries: the init segment
+// has no samples of its own, only the `stsd` describing how to decode the
+// samples that arrive in later fragments.
+func buildInitStbl(t *InitTrack) []byte {
+	empty := uint32b(0)
+	payload := append([]byte{}, t.Stsd...)
+	payload = append(payload, makeFullBox("stts", 0, empty)...)
+	payload = append(payload, makeFullBox("stsc", 0, empty)...)
+	payload = append(payload, makeFullBox("stsz", 0, append(uint32b(0), empty...))...)
+	payload = append(payload, makeFullBox("co64", 0, empty)...)
+	return makeBox("stbl", payload)
+}
+
+// buildMvex writes the MovieExtendsBox that marks this moov as belonging
+// to a fragmented file, with one `trex` per track giving the fallback
+// defaults a `tfhd` can omit.
+func buildMvex(tracks []*InitTrack) []byte {
+	var payload []byte
+	for _, t := range tracks {
+		trex := make([]byte, 20)
+		binary.BigEndian.PutUint32(trex[0:], t.ID)
+		binary.BigEndian.PutUint32(trex[4:], 1) // default_sample_description_index
+		payload = append(payload, makeFullBox("trex", 0, trex)...)
+	}
+	return makeBox("mvex", payload)
+}
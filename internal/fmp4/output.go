@@ -0,0 +1,42 @@
+package fmp4
+
+import (
+	"bytes"
+	"time"
+
+	"eaglesong.dev/hls/internal/timescale"
+)
+
+// Flush renders the packets accumulated by Append into a complete
+// RawFragment (a marshaled moof+mdat), ready to hand to Segment.Append.
+// ntp is the wallclock time of the fragment's first sample, if the
+// upstream source (e.g. an RTCP sender report on an RTSP track, or an
+// RTMP absolute timestamp) supplied one; a zero value means none is
+// available, and Segment.Format will fall back to the segment-level
+// anchor for this part. seqNum is the `moof` sequence number.
+//
+// Flush returns a zero RawFragment if fewer than two packets are pending,
+// matching makeFragment's own requirement of at least one full sample
+// interval to compute a duration from.
+func (f *TrackFragmenter) Flush(seqNum uint32, ntp time.Time) (RawFragment, error) {
+	frag := f.makeFragment()
+	if frag.trackFrag == nil {
+		return RawFragment{}, nil
+	}
+	var buf bytes.Buffer
+	if err := writeFragment(&buf, []fragmentWithData{frag}, seqNum); err != nil {
+		return RawFragment{}, err
+	}
+	var ticks uint32
+	for _, e := range frag.trackFrag.Run.Entries {
+		ticks += e.Duration
+	}
+	duration := timescale.FromScale(int64(ticks), f.timeScale)
+	return RawFragment{
+		Bytes:       buf.Bytes(),
+		Length:      buf.Len(),
+		Duration:    duration,
+		Independent: frag.packets[0].IsKeyFrame,
+		NTP:         ntp,
+	}, nil
+}
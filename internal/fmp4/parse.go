@@ -0,0 +1,117 @@
+package fmp4
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+)
+
+// Fragment is a parsed fMP4 fragment: a `moof` describing one or more track
+// runs, plus the samples that `moof` refers to, sliced directly out of the
+// accompanying `mdat`.
+type Fragment struct {
+	Seqnum uint32
+	Tracks []FragmentTrack
+}
+
+// FragmentTrack is the reconstructed run of samples for one track of a
+// Fragment.
+type FragmentTrack struct {
+	TrackID  uint32
+	BaseTime int64 // decode time of the first sample, in the track's timescale
+	Samples  []Packet
+}
+
+// Packet is one sample recovered from a `trun` entry, with defaults from
+// `tfhd` already applied. It mirrors the fields of av.Packet that the
+// fMP4 writer consumes, so a Fragment can be re-encoded unchanged.
+type Packet struct {
+	Duration        uint32
+	CompositionTime int32
+	IsKeyFrame      bool
+	Data            []byte
+}
+
+// ParseFragment reads and parses one `moof`+`mdat` pair, such as a single
+// part written by Segment.Append. It is the read-side counterpart to
+// writeFragment: every flag combination writeFragment can produce
+// (TrackRunDataOffset, TrackRunSampleDuration, TrackRunSampleSize,
+// TrackRunSampleFlags, TrackRunFirstSampleFlags, and signed TrackRunSampleCTS
+// on version-1 runs) is understood here, falling back to the per-track
+// tfhd defaults when a flag is absent.
+func ParseFragment(r io.Reader) (*Fragment, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	boxes, err := parseBoxes(data)
+	if err != nil {
+		return nil, err
+	}
+	moofBox, ok := findBox(boxes, "moof")
+	if !ok {
+		return nil, errors.New("fmp4: fragment has no moof")
+	}
+	mdatBox, ok := findBox(boxes, "mdat")
+	if !ok {
+		return nil, errors.New("fmp4: fragment has no mdat")
+	}
+	moof := new(fmp4io.MovieFrag)
+	if _, err := moof.Unmarshal(moofBox.Raw); err != nil {
+		return nil, fmt.Errorf("fmp4: parsing moof: %w", err)
+	}
+	mdatPayloadStart := len(moofBox.Raw) + 8 // offsets in trun are relative to the start of the moof box
+	frag := &Fragment{Seqnum: moof.Header.Seqnum}
+	for _, track := range moof.Tracks {
+		header, run := track.Header, track.Run
+		baseTime := int64(0)
+		if track.DecodeTime != nil {
+			baseTime = track.DecodeTime.Time
+		}
+		pos := mdatPayloadStart
+		if run.Flags&fmp4io.TrackRunDataOffset != 0 {
+			pos = int(run.DataOffset)
+		}
+		dataOff := pos - mdatPayloadStart
+		samples := make([]Packet, len(run.Entries))
+		for i, e := range run.Entries {
+			size := header.DefaultSize
+			if run.Flags&fmp4io.TrackRunSampleSize != 0 {
+				size = e.Size
+			}
+			duration := header.DefaultDuration
+			if run.Flags&fmp4io.TrackRunSampleDuration != 0 {
+				duration = e.Duration
+			}
+			flags := header.DefaultFlags
+			if i == 0 && run.Flags&fmp4io.TrackRunFirstSampleFlags != 0 {
+				flags = run.FirstSampleFlags
+			} else if run.Flags&fmp4io.TrackRunSampleFlags != 0 {
+				flags = e.Flags
+			}
+			var cts int32
+			if run.Flags&fmp4io.TrackRunSampleCTS != 0 {
+				cts = e.CTS // already signed when run.Version == 1, per the trun version matrix
+			}
+			if dataOff < 0 || dataOff+int(size) > len(mdatBox.Body) {
+				return nil, fmt.Errorf("fmp4: track %d sample %d out of range of mdat", header.TrackID, i)
+			}
+			samples[i] = Packet{
+				Duration:        duration,
+				CompositionTime: cts,
+				IsKeyFrame:      flags&fmp4io.SampleNonKeyframe == 0,
+				Data:            mdatBox.Body[dataOff : dataOff+int(size)],
+			}
+			dataOff += int(size)
+		}
+		frag.Tracks = append(frag.Tracks, FragmentTrack{
+			TrackID:  header.TrackID,
+			BaseTime: baseTime,
+			Samples:  samples,
+		})
+	}
+	return frag, nil
+}
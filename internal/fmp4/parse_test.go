@@ -0,0 +1,85 @@
+package fmp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"eaglesong.dev/hls/internal/fmp4/fmp4io"
+)
+
+// buildTestFragment hand-assembles a moof+mdat with a version-1 run carrying
+// explicit per-sample duration, size and flags and a negative composition
+// offset, so ParseFragment can be checked against known-good bytes instead
+// of round-tripping through the writer. It does not exercise the tfhd
+// defaults or the first-sample-flags override.
+func buildTestFragment(t *testing.T) []byte {
+	t.Helper()
+	entries := []fmp4io.TrackFragRunEntry{
+		{Duration: 1001, Size: 3, Flags: fmp4io.SampleNoDependencies, CTS: -200},
+		{Duration: 1001, Size: 4, Flags: fmp4io.SampleNonKeyframe, CTS: 300},
+	}
+	moof := &fmp4io.MovieFrag{
+		Header: &fmp4io.MovieFragHeader{Seqnum: 7},
+		Tracks: []*fmp4io.TrackFrag{{
+			Header: &fmp4io.TrackFragHeader{
+				Flags:   fmp4io.TrackFragDefaultBaseIsMOOF,
+				TrackID: 1,
+			},
+			DecodeTime: &fmp4io.TrackFragDecodeTime{Version: 1, Time: 9000},
+			Run: &fmp4io.TrackFragRun{
+				Version: 1,
+				Flags: fmp4io.TrackRunDataOffset | fmp4io.TrackRunSampleDuration |
+					fmp4io.TrackRunSampleSize | fmp4io.TrackRunSampleFlags | fmp4io.TrackRunSampleCTS,
+				Entries: entries,
+			},
+		}},
+	}
+	moofLen := moof.Len()
+	dataBase := moofLen + 8 // moof plus the mdat header
+	moof.Tracks[0].Run.DataOffset = uint32(dataBase)
+
+	moofBytes := make([]byte, moofLen)
+	moof.Marshal(moofBytes)
+
+	sampleData := []byte("abcdefg") // 3 bytes for sample 0, 4 for sample 1
+	mdat := make([]byte, 8+len(sampleData))
+	binary.BigEndian.PutUint32(mdat, uint32(len(mdat)))
+	copy(mdat[4:8], "mdat")
+	copy(mdat[8:], sampleData)
+
+	return append(moofBytes, mdat...)
+}
+
+func TestParseFragment(t *testing.T) {
+	frag, err := ParseFragment(bytes.NewReader(buildTestFragment(t)))
+	if err != nil {
+		t.Fatalf("ParseFragment: %v", err)
+	}
+	if frag.Seqnum != 7 {
+		t.Errorf("Seqnum = %d, want 7", frag.Seqnum)
+	}
+	if len(frag.Tracks) != 1 {
+		t.Fatalf("len(Tracks) = %d, want 1", len(frag.Tracks))
+	}
+	track := frag.Tracks[0]
+	if track.TrackID != 1 {
+		t.Errorf("TrackID = %d, want 1", track.TrackID)
+	}
+	if track.BaseTime != 9000 {
+		t.Errorf("BaseTime = %d, want 9000", track.BaseTime)
+	}
+	want := []Packet{
+		{Duration: 1001, CompositionTime: -200, IsKeyFrame: true, Data: []byte("abc")},
+		{Duration: 1001, CompositionTime: 300, IsKeyFrame: false, Data: []byte("defg")},
+	}
+	if len(track.Samples) != len(want) {
+		t.Fatalf("len(Samples) = %d, want %d", len(track.Samples), len(want))
+	}
+	for i, w := range want {
+		got := track.Samples[i]
+		if got.Duration != w.Duration || got.CompositionTime != w.CompositionTime || got.IsKeyFrame != w.IsKeyFrame || !bytes.Equal(got.Data, w.Data) {
+			t.Errorf("sample %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
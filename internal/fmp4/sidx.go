@@ -0,0 +1,59 @@
+package fmp4
+
+import "encoding/binary"
+
+// SidxEntry describes one fragment's contribution to a `sidx`: its
+// presentation duration and byte size, and whether it starts with a
+// stream access point (i.e. opens with a keyframe).
+type SidxEntry struct {
+	Duration uint32 // in the sidx's timescale
+	Size     uint32 // bytes, including the fragment's moof+mdat
+	SAP      bool
+}
+
+// BuildSidx marshals a `sidx` box describing a run of fragments, so the
+// same on-disk segment can be served as a DASH/CMAF media segment as well
+// as through the HLS playlist, without re-muxing. referenceID is the
+// track ID the index refers to; earliestPresentationTime is the
+// composition time of the first sample, in timeScale units.
+func BuildSidx(referenceID, timeScale uint32, earliestPresentationTime uint64, entries []SidxEntry) []byte {
+	payload := make([]byte, 0, 28+12*len(entries))
+	payload = append(payload, uint32b(referenceID)...)
+	payload = append(payload, uint32b(timeScale)...)
+	eptBuf := make([]byte, 8)
+	binary.BigEndian.PutUint64(eptBuf, earliestPresentationTime)
+	payload = append(payload, eptBuf...)
+	payload = append(payload, make([]byte, 8)...) // first_offset: the sidx always immediately precedes its referenced fragments
+	payload = append(payload, 0, 0)               // reserved
+	payload = append(payload, uint16b(uint16(len(entries)))...)
+	for _, e := range entries {
+		var ref uint32 // reference_type=0 (media), referenced_size in the low 31 bits
+		ref = e.Size & 0x7FFFFFFF
+		payload = append(payload, uint32b(ref)...)
+		payload = append(payload, uint32b(e.Duration)...)
+		sapStartsWith := uint32(0)
+		if e.SAP {
+			sapStartsWith = 1 << 31 // starts_with_SAP
+		}
+		sapType := uint32(1) << 28 // SAP_type=1: closed GOP starting with an IDR/keyframe
+		payload = append(payload, uint32b(sapStartsWith|sapType)...)
+	}
+	return makeVersionedFullBox("sidx", 1, 0, payload) // version 1: 64-bit earliest_presentation_time/first_offset
+}
+
+func uint16b(v uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return b
+}
+
+// StypBox marshals a `styp` (segment type) box, which should precede a
+// `sidx` the same way `ftyp` precedes `moov` in an init segment.
+func StypBox(major string, compat []string) []byte {
+	payload := make([]byte, 8+4*len(compat))
+	copy(payload[0:4], major)
+	for i, c := range compat {
+		copy(payload[8+4*i:], c)
+	}
+	return makeBox("styp", payload)
+}
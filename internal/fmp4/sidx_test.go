@@ -0,0 +1,52 @@
+package fmp4
+
+import "testing"
+
+func TestBuildSidxGolden(t *testing.T) {
+	got := BuildSidx(1, 90000, 0, []SidxEntry{
+		{Duration: 180000, Size: 1000, SAP: true},
+		{Duration: 180000, Size: 500, SAP: false},
+	})
+	want := []byte{
+		0x00, 0x00, 0x00, 0x40, // size = 8 (box header) + 4 (version/flags) + 28 + 2*12
+		's', 'i', 'd', 'x',
+		0x01, 0x00, 0x00, 0x00, // version 1, flags 0
+		0x00, 0x00, 0x00, 0x01, // reference_ID
+		0x00, 0x01, 0x5f, 0x90, // timescale = 90000
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // earliest_presentation_time
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // first_offset
+		0x00, 0x00, // reserved
+		0x00, 0x02, // reference_count
+		// entry 0: reference_type=0, referenced_size=1000, starts_with_SAP=1, SAP_type=1
+		0x00, 0x00, 0x03, 0xe8,
+		0x00, 0x02, 0xbf, 0x20, // subsegment_duration = 180000
+		0x90, 0x00, 0x00, 0x00, // starts_with_SAP<<31 | SAP_type<<28
+		// entry 1: referenced_size=500, not SAP
+		0x00, 0x00, 0x01, 0xf4,
+		0x00, 0x02, 0xbf, 0x20,
+		0x10, 0x00, 0x00, 0x00,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("len = %d, want %d\ngot:  % x\nwant: % x", len(got), len(want), got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("byte %d = %#x, want %#x\ngot:  % x\nwant: % x", i, got[i], want[i], got, want)
+		}
+	}
+}
+
+func TestFtypBoxGolden(t *testing.T) {
+	got := FtypBox("cmfc", []string{"iso6", "cmfc"})
+	want := []byte{
+		0x00, 0x00, 0x00, 0x18, // size = 8 + 8 + 4 + 4
+		'f', 't', 'y', 'p',
+		'c', 'm', 'f', 'c', // major_brand
+		0x00, 0x00, 0x00, 0x00, // minor_version
+		'i', 's', 'o', '6',
+		'c', 'm', 'f', 'c',
+	}
+	if string(got) != string(want) {
+		t.Fatalf("got  % x\nwant % x", got, want)
+	}
+}
@@ -0,0 +1,76 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+
+	"eaglesong.dev/hls/internal/fmp4"
+)
+
+// Export assembles a fast-start, progressive-download MP4 from this
+// segment's finalized fragments, with `moov` written before `mdat` so
+// players and CDNs don't need to seek to the end of the file. init must be
+// the fMP4 init segment that precedes this segment on the stream, so its
+// `stsd` boxes can be copied into the export; Export has no other source of
+// codec information.
+//
+// The segment must already be finalized.
+func (s *Segment) Export(w io.Writer, init []byte) error {
+	tracks, err := fmp4.ReadInitTracks(init)
+	if err != nil {
+		return fmt.Errorf("segment %d: reading init segment: %w", s.id, err)
+	}
+	if err := s.appendSamples(tracks); err != nil {
+		return err
+	}
+	return fmp4.Export(w, exportTrackSlice(tracks))
+}
+
+// appendSamples reads this segment's stored fragments and appends their
+// samples onto the matching entries of tracks, which must already carry
+// the stsd/timescale/ID drawn from the init segment. It is the shared
+// guts of Segment.Export and Playlist.Export, which differ only in how
+// many segments' worth of samples get appended before assembling the
+// final MP4.
+func (s *Segment) appendSamples(tracks map[uint32]*fmp4.ExportTrack) error {
+	s.mu.Lock()
+	if !s.final {
+		s.mu.Unlock()
+		return fmt.Errorf("segment %d: cannot export before it is finalized", s.id)
+	}
+	f, size := s.f, s.size
+	parts := make([]fmp4.RawFragment, len(s.parts))
+	copy(parts, s.parts)
+	s.mu.Unlock()
+
+	buf := make([]byte, size)
+	if _, err := f.ReadAt(buf, 0); err != nil && err != io.EOF {
+		return fmt.Errorf("segment %d: %w", s.id, err)
+	}
+
+	var off int64
+	for i, part := range parts {
+		frag := buf[off : off+int64(part.Length)]
+		off += int64(part.Length)
+		samples, err := fmp4.ReadFragmentSamples(frag)
+		if err != nil {
+			return fmt.Errorf("segment %d part %d: %w", s.id, i, err)
+		}
+		for id, trackSamples := range samples {
+			t, ok := tracks[id]
+			if !ok {
+				continue // fragment references a track that isn't in the supplied init segment
+			}
+			t.Samples = append(t.Samples, trackSamples...)
+		}
+	}
+	return nil
+}
+
+func exportTrackSlice(tracks map[uint32]*fmp4.ExportTrack) []*fmp4.ExportTrack {
+	ordered := make([]*fmp4.ExportTrack, 0, len(tracks))
+	for _, t := range tracks {
+		ordered = append(ordered, t)
+	}
+	return ordered
+}
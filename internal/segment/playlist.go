@@ -0,0 +1,41 @@
+package segment
+
+import (
+	"fmt"
+	"io"
+
+	"eaglesong.dev/hls/internal/fmp4"
+)
+
+// Playlist is an ordered run of segments making up an HLS rendition. It
+// supports operations, such as Export, that span more than one segment.
+type Playlist struct {
+	Segments []*Segment
+}
+
+// Export assembles a fast-start, progressive-download MP4 spanning every
+// segment with an ID in [fromID, toID], in the same way Segment.Export
+// does for a single segment, but with each segment's samples appended in
+// order so the result covers the whole window. Every segment in the range
+// must already be finalized; a live one makes Export fail. init must be
+// the fMP4 init segment in effect across that range.
+func (p *Playlist) Export(w io.Writer, init []byte, fromID, toID int64) error {
+	tracks, err := fmp4.ReadInitTracks(init)
+	if err != nil {
+		return fmt.Errorf("playlist export: reading init segment: %w", err)
+	}
+	var found bool
+	for _, s := range p.Segments {
+		if s.ID() < fromID || s.ID() > toID {
+			continue
+		}
+		found = true
+		if err := s.appendSamples(tracks); err != nil {
+			return fmt.Errorf("playlist export: %w", err)
+		}
+	}
+	if !found {
+		return fmt.Errorf("playlist export: no segment in range [%d, %d]", fromID, toID)
+	}
+	return fmp4.Export(w, exportTrackSlice(tracks))
+}
@@ -13,15 +13,31 @@ import (
 	"eaglesong.dev/hls/internal/fmp4"
 )
 
+// programDateTimeEpsilon is the smallest difference between a part's
+// wallclock time and the last one emitted (or adopted from the
+// segment-level tag) that's worth a fresh #EXT-X-PROGRAM-DATE-TIME tag.
+// The comparison is between raw wallclock times, not against the time
+// expected from the part durations in between, so parts whose NTP times
+// are further apart than this each get their own tag.
+const programDateTimeEpsilon = 2 * time.Millisecond
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
 // Segment holds a single HLS segment which can be written to in parts
 //
 // Methods of Segment are not safe for concurrent use. Use Cursor() to get a concurrent accessor.
 type Segment struct {
-	start       time.Duration
-	id          int64
-	dcn         bool
-	baseName    string
-	programTime string
+	start          time.Duration
+	id             int64
+	dcn            bool
+	baseName       string
+	programTime    string
+	programTimeVal time.Time
 	// modified while the segment is live
 	mu    sync.Mutex
 	parts []fmp4.RawFragment
@@ -42,6 +58,7 @@ func New(id int64, workDir string, start time.Duration, dcn bool, programTime ti
 	}
 	if !programTime.IsZero() {
 		s.programTime = programTime.UTC().Format("2006-01-02T15:04:05.999Z07:00")
+		s.programTimeVal = programTime
 	}
 	var err error
 	s.f, err = ioutil.TempFile(workDir, s.baseName)
@@ -77,6 +94,7 @@ func ParseName(name string) (id, part int64, ok bool) {
 // Append a complete fragment to the segment. The buffer must not be modified afterwards.
 func (s *Segment) Append(frag fmp4.RawFragment) error {
 	s.mu.Lock()
+	frag.Offset = s.size
 	s.parts = append(s.parts, frag)
 	s.size += int64(frag.Length)
 	s.mu.Unlock()
@@ -114,6 +132,20 @@ func (s *Segment) Finalize(nextSegment time.Duration) {
 	s.mu.Unlock()
 }
 
+// ReadAt implements io.ReaderAt over the segment's backing file, so a
+// caller serving HTTP range requests can satisfy any historical part (or
+// the finalized segment as a whole) straight from the single on-disk file
+// using the offset/length pairs in Format's BYTERANGE output.
+func (s *Segment) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	f := s.f
+	s.mu.Unlock()
+	if f == nil {
+		return 0, os.ErrClosed
+	}
+	return f.ReadAt(p, off)
+}
+
 // Release the backing storage associated with the segment
 func (s *Segment) Release() {
 	s.mu.Lock()
@@ -134,13 +166,33 @@ func (s *Segment) Format(b *bytes.Buffer, includeParts bool) {
 		b.WriteString("#EXT-X-DISCONTINUITY\n")
 	}
 	if includeParts {
+		lastNTP := s.programTimeVal
 		for i, part := range s.parts {
+			if !part.NTP.IsZero() {
+				switch {
+				case i == 0 && s.programTime != "":
+					// the #EXT-X-PROGRAM-DATE-TIME above already anchors this
+					// part; just adopt its NTP without printing a second tag
+					// right below the first
+					lastNTP = part.NTP
+				case absDuration(part.NTP.Sub(lastNTP)) > programDateTimeEpsilon:
+					fmt.Fprintf(b, "#EXT-X-PROGRAM-DATE-TIME:%s\n", part.NTP.UTC().Format("2006-01-02T15:04:05.999Z07:00"))
+					lastNTP = part.NTP
+				}
+			}
 			var independent string
 			if part.Independent {
 				independent = "INDEPENDENT=YES,"
 			}
-			fmt.Fprintf(b, "#EXT-X-PART:DURATION=%f,%sURI=\"%s.%d.m4s\"\n",
-				part.Duration.Seconds(), independent, s.baseName, i)
+			if s.final {
+				// the part buffers are gone; serve this part as a byte range of
+				// the single whole-segment file instead of a per-part file
+				fmt.Fprintf(b, "#EXT-X-PART:DURATION=%f,%sURI=\"%s.m4s\",BYTERANGE=\"%d@%d\"\n",
+					part.Duration.Seconds(), independent, s.baseName, part.Length, part.Offset)
+			} else {
+				fmt.Fprintf(b, "#EXT-X-PART:DURATION=%f,%sURI=\"%s.%d.m4s\"\n",
+					part.Duration.Seconds(), independent, s.baseName, i)
+			}
 		}
 	}
 	if s.final {
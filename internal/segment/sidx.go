@@ -0,0 +1,38 @@
+package segment
+
+import (
+	"io"
+
+	"eaglesong.dev/hls/internal/fmp4"
+)
+
+// Sidx builds a `sidx` describing this segment's fragments, one reference
+// per part, so the same on-disk segment can be served as a DASH/CMAF media
+// segment without re-muxing. The segment must be finalized.
+func (s *Segment) Sidx(trackID, timeScale uint32, earliestPresentationTime uint64) []byte {
+	s.mu.Lock()
+	entries := make([]fmp4.SidxEntry, len(s.parts))
+	for i, part := range s.parts {
+		entries[i] = fmp4.SidxEntry{
+			Duration: uint32(part.Duration.Seconds() * float64(timeScale)),
+			Size:     uint32(part.Length),
+			SAP:      part.Independent,
+		}
+	}
+	s.mu.Unlock()
+	return fmp4.BuildSidx(trackID, timeScale, earliestPresentationTime, entries)
+}
+
+// WriteIndex writes a `styp`+`sidx` prefix for this segment ahead of the
+// segment data itself, so a single response can serve the segment through
+// a DASH/CMAF manifest as well as the HLS playlist.
+func (s *Segment) WriteIndex(w io.Writer, trackID, timeScale uint32, earliestPresentationTime uint64) error {
+	styp := fmp4.StypBox("msdh", []string{"msdh", "msix"})
+	sidx := s.Sidx(trackID, timeScale, earliestPresentationTime)
+	for _, b := range [][]byte{styp, sidx} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}